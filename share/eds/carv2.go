@@ -0,0 +1,279 @@
+package eds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-varint"
+
+	"github.com/celestiaorg/rsmt2d"
+)
+
+// CARv2 layout constants, following https://ipld.io/specs/transport/car/carv2/.
+const (
+	carV2PragmaSize = 11
+	carV2HeaderSize = 40
+	// multihashIndexSortedCodec is the multicodec identifying the MultihashIndexSorted index
+	// format: a sequence of multihash-digest -> offset records, grouped by digest length and
+	// sorted within each group, so a given digest can be located with a binary search.
+	multihashIndexSortedCodec = 0x0401
+)
+
+// carV2Pragma is the fixed 11-byte CARv2 pragma: the CBOR encoding of the map {"version": 2}.
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// carV2Header is the 40-byte CARv2 header. It locates the inner CARv1 data payload (our usual
+// [ header | quadrants | proofs ] stream) and the index that follows it.
+type carV2Header struct {
+	Characteristics [16]byte
+	DataOffset      uint64
+	DataSize        uint64
+	IndexOffset     uint64
+}
+
+func (h carV2Header) MarshalBinary() []byte {
+	buf := make([]byte, carV2HeaderSize)
+	copy(buf[:16], h.Characteristics[:])
+	binary.LittleEndian.PutUint64(buf[16:24], h.DataOffset)
+	binary.LittleEndian.PutUint64(buf[24:32], h.DataSize)
+	binary.LittleEndian.PutUint64(buf[32:40], h.IndexOffset)
+	return buf
+}
+
+func (h *carV2Header) UnmarshalBinary(buf []byte) error {
+	if len(buf) < carV2HeaderSize {
+		return fmt.Errorf("share: carv2 header too short: got %d bytes", len(buf))
+	}
+	copy(h.Characteristics[:], buf[:16])
+	h.DataOffset = binary.LittleEndian.Uint64(buf[16:24])
+	h.DataSize = binary.LittleEndian.Uint64(buf[24:32])
+	h.IndexOffset = binary.LittleEndian.Uint64(buf[32:40])
+	return nil
+}
+
+// WriteEDSv2 writes the EDS as a CARv2 file: a pragma and header wrapping the usual CARv1
+// quadrant+proof payload (see WriteEDS), followed by a MultihashIndexSorted index mapping
+// every block's multihash digest to its offset within that payload. The index lets
+// CARv2Reader fetch a single share or NMT inner node with one seek+read, instead of the
+// linear scan a plain CARv1 reader needs.
+//
+// w must be an io.WriteSeeker: the data payload is streamed straight to w as it is produced,
+// the same way WriteEDS does, and only the 40-byte header is patched afterwards by seeking
+// back once DataSize and IndexOffset are known. This keeps WriteEDSv2's memory bound the same
+// as WriteEDS's, rather than buffering the whole payload to learn its size up front.
+func WriteEDSv2(ctx context.Context, eds *rsmt2d.ExtendedDataSquare, w io.WriteSeeker) error {
+	if _, err := w.Write(carV2Pragma); err != nil {
+		return fmt.Errorf("share: failure writing carv2 pragma: %w", err)
+	}
+	headerOffset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("share: failure getting carv2 header offset: %w", err)
+	}
+	if _, err := w.Write(make([]byte, carV2HeaderSize)); err != nil {
+		return fmt.Errorf("share: failure reserving carv2 header: %w", err)
+	}
+
+	writer, err := initializeWriter(ctx, eds, w)
+	if err != nil {
+		return fmt.Errorf("share: failure creating eds writer: %w", err)
+	}
+	writer.buildIndex = true
+
+	if err := writer.writeHeader(); err != nil {
+		return fmt.Errorf("share: failure writing carv1 header: %w", err)
+	}
+	if err := writer.writeQuadrants(); err != nil {
+		return fmt.Errorf("share: failure writing shares: %w", err)
+	}
+	if err := writer.writeProofs(); err != nil {
+		return fmt.Errorf("share: failure writing proofs: %w", err)
+	}
+	dataSize := writer.w.n
+
+	index, err := marshalMultihashIndexSorted(writer.index)
+	if err != nil {
+		return fmt.Errorf("share: failure building carv2 index: %w", err)
+	}
+	if _, err := w.Write(index); err != nil {
+		return fmt.Errorf("share: failure writing carv2 index: %w", err)
+	}
+
+	header := carV2Header{
+		DataOffset:  carV2PragmaSize + carV2HeaderSize,
+		DataSize:    dataSize,
+		IndexOffset: carV2PragmaSize + carV2HeaderSize + dataSize,
+	}
+	if _, err := w.Seek(headerOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("share: failure seeking back to carv2 header: %w", err)
+	}
+	if _, err := w.Write(header.MarshalBinary()); err != nil {
+		return fmt.Errorf("share: failure patching carv2 header: %w", err)
+	}
+	return nil
+}
+
+// marshalMultihashIndexSorted serializes records as a MultihashIndexSorted index: records are
+// grouped by digest length, and each group's records are sorted by digest so a reader can
+// binary search for a given digest without an auxiliary structure.
+func marshalMultihashIndexSorted(records []indexRecord) ([]byte, error) {
+	buckets := make(map[int][]indexRecord)
+	for _, r := range records {
+		buckets[len(r.digest)] = append(buckets[len(r.digest)], r)
+	}
+	widths := make([]int, 0, len(buckets))
+	for width := range buckets {
+		widths = append(widths, width)
+	}
+	sort.Ints(widths)
+
+	var buf bytes.Buffer
+	buf.Write(varint.ToUvarint(multihashIndexSortedCodec))
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(widths))); err != nil {
+		return nil, err
+	}
+	for _, width := range widths {
+		group := buckets[width]
+		sort.Slice(group, func(i, j int) bool {
+			return bytes.Compare(group[i].digest, group[j].digest) < 0
+		})
+
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(width+8)); err != nil {
+			return nil, err
+		}
+		if err := binary.Write(&buf, binary.LittleEndian, uint64(len(group))); err != nil {
+			return nil, err
+		}
+		for _, r := range group {
+			buf.Write(r.digest)
+			if err := binary.Write(&buf, binary.LittleEndian, r.offset); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// multihashIndex maps a multihash digest to its byte offset within a CARv1 data payload.
+type multihashIndex map[string]uint64
+
+func unmarshalMultihashIndexSorted(r io.Reader) (multihashIndex, error) {
+	br := bufio.NewReader(r)
+	codec, err := varint.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("share: failure reading carv2 index codec: %w", err)
+	}
+	if codec != multihashIndexSortedCodec {
+		return nil, fmt.Errorf("share: unsupported carv2 index codec %#x", codec)
+	}
+
+	var numWidths uint32
+	if err := binary.Read(br, binary.LittleEndian, &numWidths); err != nil {
+		return nil, fmt.Errorf("share: failure reading carv2 index width count: %w", err)
+	}
+
+	index := make(multihashIndex)
+	for i := uint32(0); i < numWidths; i++ {
+		var width uint32
+		if err := binary.Read(br, binary.LittleEndian, &width); err != nil {
+			return nil, fmt.Errorf("share: failure reading carv2 index width: %w", err)
+		}
+		var count uint64
+		if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+			return nil, fmt.Errorf("share: failure reading carv2 index count: %w", err)
+		}
+
+		digestWidth := int(width) - 8
+		for j := uint64(0); j < count; j++ {
+			digest := make([]byte, digestWidth)
+			if _, err := io.ReadFull(br, digest); err != nil {
+				return nil, fmt.Errorf("share: failure reading carv2 index digest: %w", err)
+			}
+			var offset uint64
+			if err := binary.Read(br, binary.LittleEndian, &offset); err != nil {
+				return nil, fmt.Errorf("share: failure reading carv2 index offset: %w", err)
+			}
+			index[string(digest)] = offset
+		}
+	}
+	return index, nil
+}
+
+// BlockGetter serves individual blocks (shares or NMT inner nodes) out of a CARv2-wrapped EDS
+// file by CID. It parses the file's index once on open, then does a single seek+read per
+// block, without ever instantiating an in-memory blockstore.
+type BlockGetter struct {
+	r          io.ReaderAt
+	dataOffset uint64
+	index      multihashIndex
+}
+
+// CARv2Reader opens a CARv2-wrapped EDS file written by WriteEDSv2, parsing its index so Get
+// can serve individual blocks by CID.
+func CARv2Reader(r io.ReaderAt) (*BlockGetter, error) {
+	pragma := make([]byte, carV2PragmaSize)
+	if _, err := r.ReadAt(pragma, 0); err != nil {
+		return nil, fmt.Errorf("share: failure reading carv2 pragma: %w", err)
+	}
+	if !bytes.Equal(pragma, carV2Pragma) {
+		return nil, fmt.Errorf("share: not a carv2 file")
+	}
+
+	headerBytes := make([]byte, carV2HeaderSize)
+	if _, err := r.ReadAt(headerBytes, carV2PragmaSize); err != nil {
+		return nil, fmt.Errorf("share: failure reading carv2 header: %w", err)
+	}
+	var header carV2Header
+	if err := header.UnmarshalBinary(headerBytes); err != nil {
+		return nil, fmt.Errorf("share: failure parsing carv2 header: %w", err)
+	}
+
+	indexSection := io.NewSectionReader(r, int64(header.IndexOffset), math.MaxInt64-int64(header.IndexOffset))
+	index, err := unmarshalMultihashIndexSorted(indexSection)
+	if err != nil {
+		return nil, fmt.Errorf("share: failure parsing carv2 index: %w", err)
+	}
+
+	return &BlockGetter{r: r, dataOffset: header.DataOffset, index: index}, nil
+}
+
+// Get fetches the share or NMT inner node with the given CID via the CARv2 index, doing a
+// single seek+read against the underlying file instead of scanning the CAR stream.
+func (g *BlockGetter) Get(c cid.Cid) ([]byte, error) {
+	offset, ok := g.index[string(c.Hash())]
+	if !ok {
+		return nil, format.ErrNotFound{Cid: c}
+	}
+
+	section := io.NewSectionReader(g.r, int64(g.dataOffset+offset), math.MaxInt64-int64(g.dataOffset+offset))
+	_, data, err := readLdBlock(bufio.NewReader(section))
+	if err != nil {
+		return nil, fmt.Errorf("share: failure reading block at offset %d: %w", offset, err)
+	}
+	return data, nil
+}
+
+// readLdBlock reads one util.LdWrite-framed block (a varint length, followed by a CID and its
+// raw data) from br, returning the CID and the raw data separately.
+func readLdBlock(br *bufio.Reader) (cid.Cid, []byte, error) {
+	size, err := varint.ReadUvarint(br)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("failure reading block length: %w", err)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		return cid.Undef, nil, fmt.Errorf("failure reading block: %w", err)
+	}
+	n, c, err := cid.CidFromBytes(frame)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("failure parsing block cid: %w", err)
+	}
+	return c, frame[n:], nil
+}
@@ -0,0 +1,46 @@
+package eds
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/celestiaorg/nmt"
+
+	"github.com/celestiaorg/celestia-node/share/ipld"
+)
+
+func TestWriteEDSv2RoundTrip(t *testing.T) {
+	const odsWidth = 8
+	eds := randEDS(t, odsWidth)
+
+	f, err := os.CreateTemp(t.TempDir(), "eds-carv2-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := WriteEDSv2(context.Background(), eds, f); err != nil {
+		t.Fatalf("WriteEDSv2: %v", err)
+	}
+
+	getter, err := CARv2Reader(f)
+	if err != nil {
+		t.Fatalf("CARv2Reader: %v", err)
+	}
+
+	share := prependNamespace(0, eds.GetCell(0, 0))
+	wantCID, err := ipld.CidFromNamespacedSha256(nmt.Sha256Namespace8FlaggedLeaf(share))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getter.Get(wantCID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, share) {
+		t.Fatalf("got %x, want %x", got, share)
+	}
+}
@@ -1,18 +1,14 @@
 package eds
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 
-	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
-	ds "github.com/ipfs/go-datastore"
-	dssync "github.com/ipfs/go-datastore/sync"
-	blockstore "github.com/ipfs/go-ipfs-blockstore"
-	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipld/go-car"
 	"github.com/ipld/go-car/util"
 
@@ -29,10 +25,55 @@ var ErrEmptySquare = errors.New("share: importing empty data")
 
 // writingSession contains the components needed to write an EDS to a CARv1 file with our custom node order.
 type writingSession struct {
+	// ctx is checked between shares in writeQuadrants and between inner nodes in
+	// visitInnerNode, so a caller writing a large square can still cancel the write.
+	ctx context.Context
+
+	// eds is the original, caller-supplied square. writeHeader and writeQuadrants read
+	// straight from it, so they don't need the NMT tree that writeProofs builds.
 	eds *rsmt2d.ExtendedDataSquare
-	// store is an in-memory blockstore, used to cache the inner nodes (proofs) while we walk the nmt tree.
-	store blockstore.Blockstore
-	w     io.Writer
+	w   *countingWriter
+
+	// buildIndex, when set, makes writeQuadrants and writeProofs record the offset of every
+	// block they write to index, keyed by its CID's multihash digest. Left unset by plain
+	// WriteEDS, since it has no use for the offsets.
+	buildIndex bool
+	index      []indexRecord
+
+	// visitErr carries the first error encountered by visitInnerNode, since nmt.NodeVisitor
+	// itself has no error return.
+	visitErr error
+}
+
+// countingWriter wraps an io.Writer, tracking how many bytes have been written to it so far.
+// writingSession uses the running count as the byte offset of each block it writes, for
+// callers (e.g. WriteEDSv2) that need to index those blocks.
+type countingWriter struct {
+	io.Writer
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += uint64(n)
+	return n, err
+}
+
+// indexRecord associates a block's multihash digest with its byte offset within the CARv1
+// stream written by a writingSession.
+type indexRecord struct {
+	digest []byte
+	offset uint64
+}
+
+// recordIndexEntry notes the current write offset for c, if this session is building an index.
+// It must be called immediately before the block identified by c is written, since the offset
+// it records is the offset the block will be written at.
+func (w *writingSession) recordIndexEntry(c cid.Cid) {
+	if !w.buildIndex {
+		return
+	}
+	w.index = append(w.index, indexRecord{digest: c.Hash(), offset: w.w.n})
 }
 
 // WriteEDS writes the entire EDS into the given io.Writer as CARv1 file.
@@ -40,65 +81,42 @@ type writingSession struct {
 // Order: [ Carv1Header | Q1 |  Q2 | Q3 | Q4 | inner nodes ]
 // For more information about the header: https://ipld.io/specs/transport/car/carv1/#header
 func WriteEDS(ctx context.Context, eds *rsmt2d.ExtendedDataSquare, w io.Writer) error {
-	// 1. Reimport EDS. This is needed to traverse the NMT tree and cache the inner nodes (proofs)
 	writer, err := initializeWriter(ctx, eds, w)
 	if err != nil {
 		return fmt.Errorf("share: failure creating eds writer: %w", err)
 	}
 
-	// 2. Creates and writes Carv1Header
+	// 1. Creates and writes Carv1Header
 	//    - Roots are the eds Row + Col roots
 	err = writer.writeHeader()
 	if err != nil {
 		return fmt.Errorf("share: failure writing carv1 header: %w", err)
 	}
 
-	// 3. Iterates over shares in quadrant order via eds.GetCell
+	// 2. Iterates over shares in quadrant order via eds.GetCell
 	err = writer.writeQuadrants()
 	if err != nil {
 		return fmt.Errorf("share: failure writing shares: %w", err)
 	}
 
-	// 4. Iterates over in-memory Blockstore and writes proofs to the CAR
-	err = writer.writeProofs(ctx)
+	// 3. Reimports the EDS to walk its NMT trees, streaming each inner node straight to the
+	//    CAR as it is computed.
+	err = writer.writeProofs()
 	if err != nil {
 		return fmt.Errorf("share: failure writing proofs: %w", err)
 	}
 	return nil
 }
 
-// initializeWriter reimports the EDS into an in-memory blockstore in order to cache the proofs.
+// initializeWriter validates the EDS and wraps w for writingSession's own offset tracking.
 func initializeWriter(ctx context.Context, eds *rsmt2d.ExtendedDataSquare, w io.Writer) (*writingSession, error) {
-	// we use an in-memory blockstore and an offline exchange
-	store := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
-	bs := blockservice.New(store, nil)
-	// shares are extracted from the eds so that we can reimport them to traverse
-	shares := share.ExtractEDS(eds)
-	shareCount := len(shares)
-	if shareCount == 0 {
+	if len(share.ExtractEDS(eds)) == 0 {
 		return nil, ErrEmptySquare
 	}
-	odsWidth := int(math.Sqrt(float64(shareCount)) / 2)
-	// (shareCount*2) - (odsWidth*4) is the amount of inner nodes visited
-	batchAdder := ipld.NewNmtNodeAdder(ctx, bs, format.MaxSizeBatchOption(innerNodeBatchSize(shareCount, odsWidth)))
-	// this adder ignores leaves, so that they are not added to the store we iterate through in writeProofs
-	tree := wrapper.NewErasuredNamespacedMerkleTree(uint64(odsWidth), nmt.NodeVisitor(batchAdder.VisitInnerNodes))
-	eds, err := rsmt2d.ImportExtendedDataSquare(shares, share.DefaultRSMT2DCodec(), tree.Constructor)
-	if err != nil {
-		return nil, fmt.Errorf("failure to recompute the extended data square: %w", err)
-	}
-	// compute roots
-	eds.RowRoots()
-	// commit the batch to DAG
-	err = batchAdder.Commit()
-	if err != nil {
-		return nil, fmt.Errorf("failure to commit the inner nodes to the dag: %w", err)
-	}
-
 	return &writingSession{
-		eds:   eds,
-		store: store,
-		w:     w,
+		ctx: ctx,
+		eds: eds,
+		w:   &countingWriter{Writer: w},
 	}, nil
 }
 
@@ -119,10 +137,15 @@ func (w *writingSession) writeHeader() error {
 func (w *writingSession) writeQuadrants() error {
 	shares := quadrantOrder(w.eds)
 	for _, share := range shares {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+
 		cid, err := ipld.CidFromNamespacedSha256(nmt.Sha256Namespace8FlaggedLeaf(share))
 		if err != nil {
 			return fmt.Errorf("failure to get cid from share: %w", err)
 		}
+		w.recordIndexEntry(cid)
 		err = util.LdWrite(w.w, cid.Bytes(), share)
 		if err != nil {
 			return fmt.Errorf("failure to write share: %w", err)
@@ -131,28 +154,50 @@ func (w *writingSession) writeQuadrants() error {
 	return nil
 }
 
-// writeProofs iterates over the in-memory blockstore's keys and writes all inner nodes to the CARv1 file.
-func (w *writingSession) writeProofs(ctx context.Context) error {
-	// we only stored proofs to the store, so we can just iterate over them here without getting any leaves
-	proofs, err := w.store.AllKeysChan(ctx)
+// writeProofs reimports the EDS in order to walk its row/col NMT trees, and streams each
+// inner node straight to the CARv1 stream as visitInnerNode is called during that walk.
+// Because writeQuadrants has already flushed the shares by this point, nodes can be written
+// directly to w instead of being collected in an in-memory blockstore and drained afterwards,
+// so resident memory is bounded by what the NMT tree construction itself holds rather than
+// growing with the total number of inner nodes in the square.
+func (w *writingSession) writeProofs() error {
+	shares := share.ExtractEDS(w.eds)
+	odsWidth := int(math.Sqrt(float64(len(shares))) / 2)
+
+	tree := wrapper.NewErasuredNamespacedMerkleTree(uint64(odsWidth), nmt.NodeVisitor(w.visitInnerNode))
+	reimported, err := rsmt2d.ImportExtendedDataSquare(shares, share.DefaultRSMT2DCodec(), tree.Constructor)
 	if err != nil {
-		return fmt.Errorf("failure to get all keys from the blockstore: %w", err)
+		return fmt.Errorf("failure to recompute the extended data square: %w", err)
 	}
-	for proofCid := range proofs {
-		node, err := w.store.Get(ctx, proofCid)
-		if err != nil {
-			return fmt.Errorf("failure to get proof from the blockstore: %w", err)
-		}
-		cid, err := ipld.CidFromNamespacedSha256(nmt.Sha256Namespace8FlaggedInner(node.RawData()))
-		if err != nil {
-			return fmt.Errorf("failure to get cid: %w", err)
-		}
-		err = util.LdWrite(w.w, cid.Bytes(), node.RawData())
-		if err != nil {
-			return fmt.Errorf("failure to write proof to the car: %w", err)
-		}
+	// walking the row roots is what drives visitInnerNode over every inner node
+	reimported.RowRoots()
+
+	return w.visitErr
+}
+
+// visitInnerNode is an nmt.NodeVisitor that writes each inner NMT node straight to the CARv1
+// stream as the tree is built. Leaves are visited too, but we only want proofs here, so they
+// are skipped. It also checks ctx on every inner node, since the reimport driving this visitor
+// (see writeProofs) has no cancellation point of its own.
+func (w *writingSession) visitInnerNode(_ []byte, children ...[]byte) {
+	if w.visitErr != nil || len(children) == 0 {
+		return
+	}
+	if err := w.ctx.Err(); err != nil {
+		w.visitErr = err
+		return
+	}
+
+	data := bytes.Join(children, nil)
+	cid, err := ipld.CidFromNamespacedSha256(nmt.Sha256Namespace8FlaggedInner(data))
+	if err != nil {
+		w.visitErr = fmt.Errorf("failure to get cid from inner node: %w", err)
+		return
+	}
+	w.recordIndexEntry(cid)
+	if err := util.LdWrite(w.w, cid.Bytes(), data); err != nil {
+		w.visitErr = fmt.Errorf("failure to write proof to the car: %w", err)
 	}
-	return nil
 }
 
 // quadrantOrder reorders the shares in the EDS to quadrant row-by-row order, prepending the respective namespace
@@ -213,9 +258,3 @@ func rootsToCids(eds *rsmt2d.ExtendedDataSquare) ([]cid.Cid, error) {
 	}
 	return rootCids, nil
 }
-
-// innerNodeBatchSize calculates the total number of inner nodes in an EDS,
-// to be flushed to the dagstore in a single write.
-func innerNodeBatchSize(shareCount int, odsWidth int) int {
-	return (shareCount * 2) - (odsWidth * 4)
-}
@@ -0,0 +1,404 @@
+package eds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+
+	"github.com/celestiaorg/nmt"
+	"github.com/celestiaorg/nmt/namespace"
+	"github.com/celestiaorg/rsmt2d"
+
+	"github.com/celestiaorg/celestia-node/share/ipld"
+)
+
+// namespaceIDSize is the size, in bytes, of the namespace ID prefixed to every leaf and
+// carried in the min/max fields of every NMT root in this package (see Sha256Namespace8Flagged).
+const namespaceIDSize = 8
+
+// nsIndexFooterSize is the size, in bytes, of nsIndexFooter.
+const nsIndexFooterSize = 16
+
+// nsIndexEntry records where one ODS row's shares live in the CARv1 data payload, for a row
+// whose NMT root's namespace range, [MinNamespace, MaxNamespace], covers a queried namespace.
+// A single row commonly carries more than one namespace, so matching is a range check against
+// both bounds, not an equality check against MinNamespace alone.
+// InnerProofCIDs is the row's audit path: the CIDs of the sibling nodes needed to prove the
+// row's ODS shares (leaves [0, ShareCount)) against the row root stored in the CARv1 header,
+// in the order nmt.Proof.Nodes() returns them.
+type nsIndexEntry struct {
+	MinNamespace   namespace.ID
+	MaxNamespace   namespace.ID
+	RowIndex       int
+	StartOffset    uint64
+	ShareCount     int
+	InnerProofCIDs []cid.Cid
+}
+
+// covers reports whether the row's namespace range includes ns.
+func (e nsIndexEntry) covers(ns namespace.ID) bool {
+	return bytes.Compare(e.MinNamespace, ns) <= 0 && bytes.Compare(e.MaxNamespace, ns) >= 0
+}
+
+// nsIndexFooter is a fixed-size trailer written at the very end of the file, so the namespace
+// sidecar can be located by seeking to EOF-nsIndexFooterSize without parsing the CAR.
+type nsIndexFooter struct {
+	SidecarOffset uint64
+	SidecarSize   uint64
+}
+
+func (f nsIndexFooter) MarshalBinary() []byte {
+	buf := make([]byte, nsIndexFooterSize)
+	binary.LittleEndian.PutUint64(buf[:8], f.SidecarOffset)
+	binary.LittleEndian.PutUint64(buf[8:], f.SidecarSize)
+	return buf
+}
+
+func (f *nsIndexFooter) UnmarshalBinary(buf []byte) error {
+	if len(buf) < nsIndexFooterSize {
+		return fmt.Errorf("share: namespace index footer too short: got %d bytes", len(buf))
+	}
+	f.SidecarOffset = binary.LittleEndian.Uint64(buf[:8])
+	f.SidecarSize = binary.LittleEndian.Uint64(buf[8:])
+	return nil
+}
+
+// WriteEDSWithNamespaceIndex writes the EDS as a CARv1 file exactly like WriteEDS, then
+// appends a sorted sidecar of nsIndexEntry records (one per ODS row) and a fixed-size footer
+// pointing at the sidecar. Readers can binary search the sidecar for a namespace and issue one
+// ranged read per matching row via ReadNamespaceData, instead of walking every share and NMT
+// inner node to find it.
+//
+// Entries are derived from the offsets writeQuadrants already records while writing, not by
+// re-reading the file afterwards.
+func WriteEDSWithNamespaceIndex(ctx context.Context, eds *rsmt2d.ExtendedDataSquare, w io.Writer) error {
+	writer, err := initializeWriter(ctx, eds, w)
+	if err != nil {
+		return fmt.Errorf("share: failure creating eds writer: %w", err)
+	}
+	writer.buildIndex = true
+
+	if err := writer.writeHeader(); err != nil {
+		return fmt.Errorf("share: failure writing carv1 header: %w", err)
+	}
+	if err := writer.writeQuadrants(); err != nil {
+		return fmt.Errorf("share: failure writing shares: %w", err)
+	}
+	if err := writer.writeProofs(); err != nil {
+		return fmt.Errorf("share: failure writing proofs: %w", err)
+	}
+
+	entries, err := namespaceIndexEntries(writer)
+	if err != nil {
+		return fmt.Errorf("share: failure building namespace index: %w", err)
+	}
+
+	sidecarOffset := writer.w.n
+	sidecar, err := marshalNamespaceIndex(entries)
+	if err != nil {
+		return fmt.Errorf("share: failure serializing namespace index: %w", err)
+	}
+	if _, err := writer.w.Write(sidecar); err != nil {
+		return fmt.Errorf("share: failure writing namespace index: %w", err)
+	}
+
+	footer := nsIndexFooter{SidecarOffset: sidecarOffset, SidecarSize: uint64(len(sidecar))}
+	if _, err := writer.w.Write(footer.MarshalBinary()); err != nil {
+		return fmt.Errorf("share: failure writing namespace index footer: %w", err)
+	}
+	return nil
+}
+
+// namespaceIndexEntries builds one nsIndexEntry per ODS row from the EDS's row roots and the
+// offsets writer.index recorded while writeQuadrants ran. writeQuadrants writes all of
+// quadrant 0 (the ODS, in row-major order) before any other quadrant, so the first
+// odsWidth*odsWidth entries in writer.index are exactly the ODS shares in row-major order;
+// entry i*odsWidth is the start of row i.
+func namespaceIndexEntries(writer *writingSession) ([]nsIndexEntry, error) {
+	odsWidth := writer.eds.Width() / 2
+	rowRoots := writer.eds.RowRoots()
+
+	entries := make([]nsIndexEntry, odsWidth)
+	for i := 0; i < int(odsWidth); i++ {
+		root := rowRoots[i]
+		if len(root) < 2*namespaceIDSize {
+			return nil, fmt.Errorf("row %d root too short to contain a namespace range", i)
+		}
+
+		auditPath, err := rowAuditPathCIDs(writer.eds, i, int(odsWidth))
+		if err != nil {
+			return nil, fmt.Errorf("failure computing audit path for row %d: %w", i, err)
+		}
+
+		entries[i] = nsIndexEntry{
+			MinNamespace:   namespace.ID(root[:namespaceIDSize]),
+			MaxNamespace:   namespace.ID(root[namespaceIDSize : 2*namespaceIDSize]),
+			RowIndex:       i,
+			StartOffset:    writer.index[i*int(odsWidth)].offset,
+			ShareCount:     int(odsWidth),
+			InnerProofCIDs: auditPath,
+		}
+	}
+
+	// sorted by MinNamespace so ReadNamespaceData can stop its scan at the first entry whose
+	// MinNamespace is past a queried namespace, instead of always scanning every entry.
+	sort.Slice(entries, func(i, j int) bool {
+		if c := bytes.Compare(entries[i].MinNamespace, entries[j].MinNamespace); c != 0 {
+			return c < 0
+		}
+		return entries[i].RowIndex < entries[j].RowIndex
+	})
+	return entries, nil
+}
+
+// rowAuditPathCIDs builds row's full NMT tree (its ODS shares followed by its parity shares)
+// and proves the ODS portion (leaves [0, odsWidth)) against the row root, returning the CIDs
+// of the sibling nodes nmt.Proof.Nodes() reports for that range. Those siblings are a subset
+// of the inner nodes writeProofs already wrote to the CARv1 stream, so a caller can fetch them
+// by CID via a CARv2Reader (see WriteEDSv2) or a scan of the proofs section.
+func rowAuditPathCIDs(eds *rsmt2d.ExtendedDataSquare, row, odsWidth int) ([]cid.Cid, error) {
+	tree := nmt.New(sha256.New(), nmt.NamespaceIDSize(namespaceIDSize), nmt.IgnoreMaxNamespace(true))
+	for col := 0; col < 2*odsWidth; col++ {
+		quadrant := 0
+		if col >= odsWidth {
+			quadrant = 1
+		}
+		leaf := prependNamespace(quadrant, eds.GetCell(uint(row), uint(col)))
+		if err := tree.Push(leaf); err != nil {
+			return nil, fmt.Errorf("failure to push row %d leaf %d: %w", row, col, err)
+		}
+	}
+
+	proof, err := tree.ProveRange(0, odsWidth)
+	if err != nil {
+		return nil, fmt.Errorf("failure to prove row %d data range: %w", row, err)
+	}
+
+	cids := make([]cid.Cid, len(proof.Nodes()))
+	for i, node := range proof.Nodes() {
+		c, err := ipld.CidFromNamespacedSha256(node)
+		if err != nil {
+			return nil, fmt.Errorf("failure to get cid from row %d proof node %d: %w", row, i, err)
+		}
+		cids[i] = c
+	}
+	return cids, nil
+}
+
+// marshalNamespaceIndex serializes entries, already sorted by MinNamespace, as the namespace
+// sidecar section.
+func marshalNamespaceIndex(entries []nsIndexEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(varint.ToUvarint(uint64(len(entries))))
+	for _, e := range entries {
+		buf.WriteByte(byte(len(e.MinNamespace)))
+		buf.Write(e.MinNamespace)
+		buf.WriteByte(byte(len(e.MaxNamespace)))
+		buf.Write(e.MaxNamespace)
+		buf.Write(varint.ToUvarint(uint64(e.RowIndex)))
+		buf.Write(varint.ToUvarint(e.StartOffset))
+		buf.Write(varint.ToUvarint(uint64(e.ShareCount)))
+		buf.Write(varint.ToUvarint(uint64(len(e.InnerProofCIDs))))
+		for _, c := range e.InnerProofCIDs {
+			cidBytes := c.Bytes()
+			buf.Write(varint.ToUvarint(uint64(len(cidBytes))))
+			buf.Write(cidBytes)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalNamespaceIndex(r io.Reader) ([]nsIndexEntry, error) {
+	br := bufio.NewReader(r)
+	count, err := varint.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading namespace index entry count: %w", err)
+	}
+
+	entries := make([]nsIndexEntry, count)
+	for i := range entries {
+		minNsLen, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failure reading min namespace id length: %w", err)
+		}
+		minNs := make([]byte, minNsLen)
+		if _, err := io.ReadFull(br, minNs); err != nil {
+			return nil, fmt.Errorf("failure reading min namespace id: %w", err)
+		}
+		maxNsLen, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failure reading max namespace id length: %w", err)
+		}
+		maxNs := make([]byte, maxNsLen)
+		if _, err := io.ReadFull(br, maxNs); err != nil {
+			return nil, fmt.Errorf("failure reading max namespace id: %w", err)
+		}
+
+		rowIndex, err := varint.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading row index: %w", err)
+		}
+		startOffset, err := varint.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading start offset: %w", err)
+		}
+		shareCount, err := varint.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading share count: %w", err)
+		}
+		proofCount, err := varint.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading proof cid count: %w", err)
+		}
+
+		cids := make([]cid.Cid, proofCount)
+		for j := range cids {
+			cidLen, err := varint.ReadUvarint(br)
+			if err != nil {
+				return nil, fmt.Errorf("failure reading proof cid length: %w", err)
+			}
+			cidBytes := make([]byte, cidLen)
+			if _, err := io.ReadFull(br, cidBytes); err != nil {
+				return nil, fmt.Errorf("failure reading proof cid: %w", err)
+			}
+			_, c, err := cid.CidFromBytes(cidBytes)
+			if err != nil {
+				return nil, fmt.Errorf("failure parsing proof cid: %w", err)
+			}
+			cids[j] = c
+		}
+
+		entries[i] = nsIndexEntry{
+			MinNamespace:   namespace.ID(minNs),
+			MaxNamespace:   namespace.ID(maxNs),
+			RowIndex:       int(rowIndex),
+			StartOffset:    startOffset,
+			ShareCount:     int(shareCount),
+			InnerProofCIDs: cids,
+		}
+	}
+	return entries, nil
+}
+
+// NamespaceRowProof pairs one ODS row's complete share set with its inclusion proof against
+// that row's root. Shares is always the row's full, unfiltered ODS leaf set — not just the
+// leaves whose own namespace is the one that was queried — because Proof only attests to the
+// leaf range it was computed over ([0, ShareCount)); returning a smaller, filtered slice of
+// shares here would make Shares and Proof describe different things. Callers that want only
+// the shares belonging to a specific namespace should filter Shares by each share's own
+// namespaceIDSize-byte prefix themselves.
+type NamespaceRowProof struct {
+	RowIndex int
+	Shares   [][]byte
+	Proof    nmt.Proof
+}
+
+// ReadNamespaceData reads every ODS row whose NMT root's namespace range intersects ns out of
+// a CARv1 file of the given size, written by WriteEDSWithNamespaceIndex, each paired with a
+// proof of its inclusion. It seeks straight to the footer, binary searches the sidecar for ns,
+// and issues one ranged read per matching row, instead of walking every share and NMT inner
+// node in the file.
+//
+// A namespace commonly spans more than one row, so callers should expect more than one result;
+// a single collapsed (shares, proof) pair can't faithfully represent that, which is why this
+// returns one NamespaceRowProof per matching row instead.
+//
+// size must be the total length of the data r reads from (e.g. an *os.File's size from Stat),
+// since io.ReaderAt has no way to report it and the footer lives at its very end.
+func ReadNamespaceData(r io.ReaderAt, ns namespace.ID, size int64) ([]NamespaceRowProof, error) {
+	if size < nsIndexFooterSize {
+		return nil, fmt.Errorf("share: file too small to contain a namespace index footer")
+	}
+
+	footerBytes := make([]byte, nsIndexFooterSize)
+	if _, err := r.ReadAt(footerBytes, size-nsIndexFooterSize); err != nil {
+		return nil, fmt.Errorf("share: failure reading namespace index footer: %w", err)
+	}
+	var footer nsIndexFooter
+	if err := footer.UnmarshalBinary(footerBytes); err != nil {
+		return nil, fmt.Errorf("share: failure parsing namespace index footer: %w", err)
+	}
+
+	sidecar := io.NewSectionReader(r, int64(footer.SidecarOffset), int64(footer.SidecarSize))
+	entries, err := unmarshalNamespaceIndex(sidecar)
+	if err != nil {
+		return nil, fmt.Errorf("share: failure parsing namespace index: %w", err)
+	}
+
+	// entries are sorted by MinNamespace, but ranges can overlap (a row commonly carries more
+	// than one namespace), so every entry whose range could cover ns has to be checked; the
+	// scan runs from the first entry and stops at the first entry whose MinNamespace is past ns.
+	start := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].MinNamespace, ns) > 0
+	})
+
+	var results []NamespaceRowProof
+	for _, e := range entries[:start] {
+		if !e.covers(ns) {
+			continue
+		}
+		rowShares, err := readRowShares(r, e)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes := make([][]byte, len(e.InnerProofCIDs))
+		for i, c := range e.InnerProofCIDs {
+			node, err := namespacedHashFromCID(c)
+			if err != nil {
+				return nil, fmt.Errorf("share: failure decoding row %d proof node %d: %w", e.RowIndex, i, err)
+			}
+			nodes[i] = node
+		}
+
+		results = append(results, NamespaceRowProof{
+			RowIndex: e.RowIndex,
+			Shares:   rowShares,
+			Proof:    nmt.NewInclusionProof(0, e.ShareCount, nodes, true),
+		})
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("share: namespace %x not found", []byte(ns))
+	}
+	return results, nil
+}
+
+// namespacedHashFromCID recovers the namespaced hash bytes an inner-node or leaf CID was built
+// from. ipld.CidFromNamespacedSha256 wraps those bytes in an identity multihash precisely so
+// they can be read back out of the CID itself, without fetching the block they identify.
+func namespacedHashFromCID(c cid.Cid) ([]byte, error) {
+	decoded, err := mh.Decode(c.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failure decoding multihash: %w", err)
+	}
+	return decoded.Digest, nil
+}
+
+// readRowShares reads all e.ShareCount consecutive length-prefixed blocks starting at
+// e.StartOffset, stripping the per-quadrant namespace prefix writeQuadrants adds. It always
+// returns the row's complete share set, unfiltered by namespace — see NamespaceRowProof for
+// why a partial, namespace-filtered slice can't be paired with this row's proof.
+func readRowShares(r io.ReaderAt, e nsIndexEntry) ([][]byte, error) {
+	section := io.NewSectionReader(r, int64(e.StartOffset), math.MaxInt64-int64(e.StartOffset))
+	br := bufio.NewReader(section)
+
+	shares := make([][]byte, e.ShareCount)
+	for i := range shares {
+		_, data, err := readLdBlock(br)
+		if err != nil {
+			return nil, fmt.Errorf("share: failure reading row %d share %d: %w", e.RowIndex, i, err)
+		}
+		shares[i] = data[namespaceIDSize:]
+	}
+	return shares, nil
+}
@@ -0,0 +1,58 @@
+package eds
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/celestiaorg/celestia-app/pkg/appconsts"
+	"github.com/celestiaorg/celestia-app/pkg/wrapper"
+	"github.com/celestiaorg/nmt"
+	"github.com/celestiaorg/rsmt2d"
+
+	"github.com/celestiaorg/celestia-node/share"
+)
+
+// BenchmarkWriteEDS compares allocations writing EDSes of increasing width. writeProofs'
+// streaming NodeVisitor (see its doc comment) writes inner nodes out instead of buffering
+// them; the reported figures still grow with the total share count, since WriteEDS also
+// copies and reimports every share.
+func BenchmarkWriteEDS(b *testing.B) {
+	for _, odsWidth := range []int{128, 256, 512} {
+		eds := randEDS(b, odsWidth)
+		b.Run(fmt.Sprintf("odsWidth=%d", odsWidth), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := WriteEDS(context.Background(), eds, io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// randEDS builds an EDS of the given ODS width out of random shares with strictly increasing
+// namespace IDs, for use as benchmark and test input.
+func randEDS(tb testing.TB, odsWidth int) *rsmt2d.ExtendedDataSquare {
+	tb.Helper()
+
+	shares := make([][]byte, odsWidth*odsWidth)
+	for i := range shares {
+		s := make([]byte, appconsts.ShareSize)
+		binary.BigEndian.PutUint64(s[:namespaceIDSize], uint64(i))
+		if _, err := rand.Read(s[namespaceIDSize:]); err != nil {
+			tb.Fatal(err)
+		}
+		shares[i] = s
+	}
+
+	tree := wrapper.NewErasuredNamespacedMerkleTree(uint64(odsWidth), nmt.NodeVisitor(func(_ []byte, _ ...[]byte) {}))
+	eds, err := rsmt2d.ComputeExtendedDataSquare(shares, share.DefaultRSMT2DCodec(), tree.Constructor)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return eds
+}